@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// logBuffer collects logs delivered over a live subscription while a
+// backfill scan is in progress, so they can be replayed in order once the
+// scan catches up instead of being dropped.
+type logBuffer struct {
+	mu   sync.Mutex
+	logs []types.Log
+}
+
+func (b *logBuffer) add(vLog types.Log) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.logs = append(b.logs, vLog)
+}
+
+func (b *logBuffer) drain() []types.Log {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	drained := b.logs
+	b.logs = nil
+	return drained
+}
+
+const (
+	defaultBackfillWindow = uint64(1000)
+	backfillMaxRetries    = 8
+	backfillBaseBackoff   = time.Second
+	backfillMaxBackoff    = 60 * time.Second
+)
+
+// backfillWindow returns the configured block range to scan per RPC call,
+// falling back to defaultBackfillWindow when BACKFILL_BLOCK_WINDOW is unset
+// or invalid.
+func backfillWindow() uint64 {
+	if v := envUint64("BACKFILL_BLOCK_WINDOW"); v > 0 {
+		return v
+	}
+	return defaultBackfillWindow
+}
+
+// filterLogsWithRetry calls FilterLogs, retrying transient RPC errors with
+// exponential backoff before giving up.
+func filterLogsWithRetry(ctx context.Context, client *ethclient.Client, query ethereum.FilterQuery) ([]types.Log, error) {
+	var lastErr error
+	backoff := backfillBaseBackoff
+	for attempt := 0; attempt < backfillMaxRetries; attempt++ {
+		logs, err := client.FilterLogs(ctx, query)
+		if err == nil {
+			return logs, nil
+		}
+		lastErr = err
+		rpcErrorsTotal.WithLabelValues("FilterLogs").Inc()
+		logger.Warn("backfill FilterLogs attempt failed", "attempt", attempt+1, "max_attempts", backfillMaxRetries, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = doubleBackoff(backoff, backfillMaxBackoff)
+	}
+	return nil, lastErr
+}
+
+// runBackfill scans [fromBlock, head] in fixed-size windows, invoking onLog
+// for every matching log in order and persisting a checkpoint after each
+// window so a restart resumes exactly where this call left off. Because the
+// chain keeps producing blocks while we scan, head is re-read on every
+// iteration; once the rest of the range fits within one window of the
+// (then-current) head, it is scanned as the last window and the loop returns
+// that head, handing control back to the live subscription path. On error it
+// returns the first block it did not finish.
+func runBackfill(
+	ctx context.Context,
+	client *ethclient.Client,
+	db *sql.DB,
+	contractAddr common.Address,
+	eventSig string,
+	fromBlock uint64,
+	onLog func(types.Log) error,
+) (uint64, error) {
+	window := backfillWindow()
+
+	for {
+		if ctx.Err() != nil {
+			return fromBlock, ctx.Err()
+		}
+
+		head, err := client.BlockNumber(ctx)
+		if err != nil {
+			rpcErrorsTotal.WithLabelValues("BlockNumber").Inc()
+			logger.Warn("backfill failed to fetch head block", "error", err)
+			time.Sleep(backfillBaseBackoff)
+			continue
+		}
+
+		if fromBlock > head {
+			return head, nil
+		}
+
+		// Close enough to head that this is the last window before the live
+		// subscription path takes over.
+		final := head-fromBlock < window
+
+		toBlock := fromBlock + window - 1
+		if toBlock > head {
+			toBlock = head
+		}
+
+		query := ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(fromBlock),
+			ToBlock:   new(big.Int).SetUint64(toBlock),
+			Addresses: []common.Address{contractAddr},
+		}
+
+		logs, err := filterLogsWithRetry(ctx, client, query)
+		if err != nil {
+			return fromBlock, err
+		}
+
+		for _, vLog := range logs {
+			if err := onLog(vLog); err != nil {
+				return fromBlock, err
+			}
+		}
+
+		if err := recordBlockRange(ctx, client, db, fromBlock, toBlock); err != nil {
+			logger.Warn("backfill failed to record block range", "from_block", fromBlock, "to_block", toBlock, "error", err)
+		}
+
+		if err := saveCheckpoint(db, contractAddr.Hex(), eventSig, toBlock, 0); err != nil {
+			logger.Warn("backfill failed to save checkpoint", "block_number", toBlock, "error", err)
+		}
+
+		logger.Info("backfill scanned window", "from_block", fromBlock, "to_block", toBlock, "log_count", len(logs), "head", head)
+		recordProgress(head, toBlock)
+		fromBlock = toBlock + 1
+		if final {
+			return head, nil
+		}
+	}
+}
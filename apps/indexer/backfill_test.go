@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestBackfillWindow(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("BACKFILL_BLOCK_WINDOW", "")
+		if got := backfillWindow(); got != defaultBackfillWindow {
+			t.Errorf("got %d, want %d", got, defaultBackfillWindow)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		t.Setenv("BACKFILL_BLOCK_WINDOW", "250")
+		if got := backfillWindow(); got != 250 {
+			t.Errorf("got %d, want 250", got)
+		}
+	})
+}
+
+func TestLogBuffer(t *testing.T) {
+	var b logBuffer
+
+	if drained := b.drain(); len(drained) != 0 {
+		t.Fatalf("drain on empty buffer = %v, want empty", drained)
+	}
+
+	b.add(types.Log{Index: 0})
+	b.add(types.Log{Index: 1})
+
+	drained := b.drain()
+	if len(drained) != 2 {
+		t.Fatalf("got %d logs, want 2", len(drained))
+	}
+	if drained[0].Index != 0 || drained[1].Index != 1 {
+		t.Errorf("logs out of order: %+v", drained)
+	}
+
+	if drained := b.drain(); len(drained) != 0 {
+		t.Errorf("drain after drain = %v, want empty", drained)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"database/sql"
+)
+
+// Checkpoint records how far the indexer has durably processed a given
+// contract + event signature, so a restart resumes instead of re-scanning
+// from genesis or re-subscribing blind.
+type Checkpoint struct {
+	LastBlock    uint64
+	LastLogIndex uint
+}
+
+// dbExecer is satisfied by both *sql.DB and *sql.Tx, letting checkpoint
+// writes happen either standalone or as part of a handler's transaction.
+type dbExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// loadCheckpoint returns the last persisted progress for (contractAddr, eventSig),
+// or the zero Checkpoint if none has been recorded yet.
+func loadCheckpoint(db dbExecer, contractAddr, eventSig string) (Checkpoint, error) {
+	var cp Checkpoint
+	err := db.QueryRow(`
+		SELECT last_block, last_log_index
+		FROM indexer_checkpoints
+		WHERE contract_address = $1 AND event_sig = $2
+	`, contractAddr, eventSig).Scan(&cp.LastBlock, &cp.LastLogIndex)
+
+	if err == sql.ErrNoRows {
+		return Checkpoint{}, nil
+	}
+	return cp, err
+}
+
+// saveCheckpoint durably records progress so the next window (or process
+// restart) resumes from exactly this point.
+func saveCheckpoint(db dbExecer, contractAddr, eventSig string, lastBlock uint64, lastLogIndex uint) error {
+	_, err := db.Exec(`
+		INSERT INTO indexer_checkpoints (contract_address, event_sig, last_block, last_log_index, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (contract_address, event_sig)
+		DO UPDATE SET last_block = $3, last_log_index = $4, updated_at = NOW()
+	`, contractAddr, eventSig, lastBlock, lastLogIndex)
+	return err
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// envUint64 reads an environment variable as a uint64, returning 0 if unset
+// or unparseable.
+func envUint64(key string) uint64 {
+	v, err := strconv.ParseUint(os.Getenv(key), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// doubleBackoff doubles d, capping at max.
+func doubleBackoff(d, max time.Duration) time.Duration {
+	next := d * 2
+	if next > max {
+		return max
+	}
+	return next
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnvUint64(t *testing.T) {
+	t.Run("unset", func(t *testing.T) {
+		t.Setenv("TEST_ENV_UINT64", "")
+		if got := envUint64("TEST_ENV_UINT64"); got != 0 {
+			t.Errorf("got %d, want 0", got)
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		t.Setenv("TEST_ENV_UINT64", "42")
+		if got := envUint64("TEST_ENV_UINT64"); got != 42 {
+			t.Errorf("got %d, want 42", got)
+		}
+	})
+
+	t.Run("unparseable", func(t *testing.T) {
+		t.Setenv("TEST_ENV_UINT64", "not-a-number")
+		if got := envUint64("TEST_ENV_UINT64"); got != 0 {
+			t.Errorf("got %d, want 0", got)
+		}
+	})
+
+	t.Run("negative rejected", func(t *testing.T) {
+		t.Setenv("TEST_ENV_UINT64", "-1")
+		if got := envUint64("TEST_ENV_UINT64"); got != 0 {
+			t.Errorf("got %d, want 0", got)
+		}
+	})
+}
+
+func TestDoubleBackoff(t *testing.T) {
+	tests := []struct {
+		name string
+		d    time.Duration
+		max  time.Duration
+		want time.Duration
+	}{
+		{"doubles under cap", time.Second, time.Minute, 2 * time.Second},
+		{"caps at max", 40 * time.Second, 60 * time.Second, 60 * time.Second},
+		{"already at max", 60 * time.Second, 60 * time.Second, 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := doubleBackoff(tt.d, tt.max); got != tt.want {
+				t.Errorf("doubleBackoff(%v, %v) = %v, want %v", tt.d, tt.max, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// EventHandler decodes one contract event and writes its effects to the
+// database. New events are added by implementing this interface and
+// registering an instance with a HandlerRegistry, without touching the
+// dispatch loop in indexEvents/pollEvents.
+type EventHandler interface {
+	Topic0() common.Hash
+	Handle(ctx context.Context, tx *sql.Tx, vLog types.Log) error
+}
+
+// registeredHandler pairs a handler with the event-sig key it checkpoints
+// under, since EventHandler itself doesn't expose a name.
+type registeredHandler struct {
+	sig     string
+	handler EventHandler
+}
+
+// HandlerRegistry dispatches a log to whichever EventHandler matches its
+// topic0, running the handler's writes and the resulting checkpoint update
+// in a single transaction per log.
+type HandlerRegistry struct {
+	contractAddr string
+	client       atomic.Pointer[ethclient.Client]
+	byTopic      map[common.Hash]registeredHandler
+}
+
+// NewHandlerRegistry builds a registry for contractAddr, indexing the given
+// handlers by their Topic0(). client is used to verify block canonicality
+// (see ensureCanonical) before a handler is invoked, and can be swapped via
+// SetClient whenever the subscription supervisor redials.
+func NewHandlerRegistry(contractAddr common.Address, client *ethclient.Client, handlers ...interface {
+	EventHandler
+	name() string
+}) *HandlerRegistry {
+	r := &HandlerRegistry{
+		contractAddr: contractAddr.Hex(),
+		byTopic:      make(map[common.Hash]registeredHandler, len(handlers)),
+	}
+	r.client.Store(client)
+	for _, h := range handlers {
+		r.byTopic[h.Topic0()] = registeredHandler{sig: h.name(), handler: h}
+	}
+	return r
+}
+
+// SetClient swaps the ethclient used for canonicality checks, used after the
+// subscription supervisor redials a dropped connection.
+func (r *HandlerRegistry) SetClient(client *ethclient.Client) {
+	r.client.Store(client)
+}
+
+// Dispatch routes vLog to its registered handler, if any. A log with no
+// matching handler is ignored. Tests can exercise a single handler directly
+// by calling Handle with a mock *sql.Tx and a synthetic log.
+func (r *HandlerRegistry) Dispatch(ctx context.Context, db *sql.DB, vLog types.Log) error {
+	if len(vLog.Topics) == 0 {
+		return nil
+	}
+	rh, ok := r.byTopic[vLog.Topics[0]]
+	if !ok {
+		return nil
+	}
+
+	start := time.Now()
+
+	if err := ensureCanonical(ctx, r.client.Load(), db, vLog); err != nil {
+		return fmt.Errorf("verify canonicality: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := rh.handler.Handle(ctx, tx, vLog); err != nil {
+		dbInsertErrorsTotal.Inc()
+		logsProcessedTotal.WithLabelValues(rh.sig, "error").Inc()
+		return fmt.Errorf("handle %s: %w", rh.sig, err)
+	}
+
+	if err := saveCheckpoint(tx, r.contractAddr, rh.sig, vLog.BlockNumber, vLog.Index); err != nil {
+		dbInsertErrorsTotal.Inc()
+		return fmt.Errorf("checkpoint %s: %w", rh.sig, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		dbInsertErrorsTotal.Inc()
+		return err
+	}
+
+	logsProcessedTotal.WithLabelValues(rh.sig, "ok").Inc()
+	logProcessingSeconds.Observe(time.Since(start).Seconds())
+	return nil
+}
+
+// outcomeNames maps the GameEnded outcome enum to its display string.
+var outcomeNames = map[uint8]string{
+	0: "lose",
+	1: "win",
+	2: "push",
+	3: "blackjack",
+	4: "surrender",
+}
+
+// GameEndedEvent mirrors the GameEnded event's non-indexed fields.
+type GameEndedEvent struct {
+	Player    common.Address
+	BetAmount *big.Int
+	Payout    *big.Int
+	Outcome   uint8
+}
+
+// GameEndedHandler records a finished game. XP is granted separately by
+// creditFinalizedXP once the block clears the configured finality lag, so a
+// reorg never leaves a player with XP for a game that no longer exists on
+// the canonical chain.
+type GameEndedHandler struct {
+	contractABI abi.ABI
+}
+
+func NewGameEndedHandler(contractABI abi.ABI) *GameEndedHandler {
+	return &GameEndedHandler{contractABI: contractABI}
+}
+
+func (h *GameEndedHandler) name() string { return "GameEnded" }
+
+func (h *GameEndedHandler) Topic0() common.Hash {
+	return h.contractABI.Events["GameEnded"].ID
+}
+
+func (h *GameEndedHandler) Handle(ctx context.Context, tx *sql.Tx, vLog types.Log) error {
+	var event GameEndedEvent
+	event.Player = common.HexToAddress(vLog.Topics[1].Hex())
+
+	if err := h.contractABI.UnpackIntoInterface(&event, "GameEnded", vLog.Data); err != nil {
+		return fmt.Errorf("unpack GameEnded: %w", err)
+	}
+
+	outcome := outcomeNames[event.Outcome]
+	pnl := new(big.Int).Sub(event.Payout, event.BetAmount)
+
+	logger.Info("game ended",
+		"tx_hash", vLog.TxHash.Hex(),
+		"block_number", vLog.BlockNumber,
+		"player", event.Player.Hex(),
+		"bet_amount", event.BetAmount.String(),
+		"payout", event.Payout.String(),
+		"outcome", outcome,
+	)
+
+	var gameID, playerUserID sql.NullInt64
+	err := tx.QueryRow(`
+		INSERT INTO games (
+			user_id, game_type, tx_hash, log_index, block_number, block_hash,
+			bet_amount, currency, payout, pnl, outcome,
+			started_at, ended_at
+		)
+		SELECT
+			u.id, 'blackjack', $1, $2, $3, $4,
+			$5, 'ETH', $6, $7, $8,
+			NOW(), NOW()
+		FROM users u
+		WHERE u.wallet_address = $9
+		ON CONFLICT (tx_hash, log_index) DO NOTHING
+		RETURNING id, user_id
+	`,
+		vLog.TxHash.Hex(),
+		vLog.Index,
+		vLog.BlockNumber,
+		vLog.BlockHash.Hex(),
+		event.BetAmount.String(),
+		event.Payout.String(),
+		pnl.String(),
+		outcome,
+		strings.ToLower(event.Player.Hex()),
+	).Scan(&gameID, &playerUserID)
+	if err == sql.ErrNoRows {
+		// Either the (tx_hash, log_index) pair already exists or no user
+		// matched the wallet.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("insert game: %w", err)
+	}
+
+	if err := processReferralEarnings(tx, gameID.Int64, playerUserID.Int64, event.BetAmount, event.Payout); err != nil {
+		return fmt.Errorf("process referral earnings: %w", err)
+	}
+
+	return nil
+}
+
+// GameStartedEvent mirrors the GameStarted event's non-indexed fields.
+type GameStartedEvent struct {
+	Player    common.Address
+	BetAmount *big.Int
+}
+
+// GameStartedHandler records that a player opened a round.
+type GameStartedHandler struct {
+	contractABI abi.ABI
+}
+
+func NewGameStartedHandler(contractABI abi.ABI) *GameStartedHandler {
+	return &GameStartedHandler{contractABI: contractABI}
+}
+
+func (h *GameStartedHandler) name() string { return "GameStarted" }
+
+func (h *GameStartedHandler) Topic0() common.Hash {
+	return h.contractABI.Events["GameStarted"].ID
+}
+
+func (h *GameStartedHandler) Handle(ctx context.Context, tx *sql.Tx, vLog types.Log) error {
+	var event GameStartedEvent
+	event.Player = common.HexToAddress(vLog.Topics[1].Hex())
+
+	if err := h.contractABI.UnpackIntoInterface(&event, "GameStarted", vLog.Data); err != nil {
+		return fmt.Errorf("unpack GameStarted: %w", err)
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO game_starts (user_id, tx_hash, log_index, block_number, bet_amount)
+		SELECT u.id, $1, $2, $3, $4
+		FROM users u
+		WHERE u.wallet_address = $5
+		ON CONFLICT (tx_hash, log_index) DO NOTHING
+	`,
+		vLog.TxHash.Hex(),
+		vLog.Index,
+		vLog.BlockNumber,
+		event.BetAmount.String(),
+		strings.ToLower(event.Player.Hex()),
+	)
+	if err != nil {
+		return fmt.Errorf("insert game_starts: %w", err)
+	}
+	return nil
+}
+
+// BetPlacedEvent mirrors the BetPlaced event's non-indexed fields.
+type BetPlacedEvent struct {
+	Player common.Address
+	Amount *big.Int
+}
+
+// BetPlacedHandler records an individual bet within a round.
+type BetPlacedHandler struct {
+	contractABI abi.ABI
+}
+
+func NewBetPlacedHandler(contractABI abi.ABI) *BetPlacedHandler {
+	return &BetPlacedHandler{contractABI: contractABI}
+}
+
+func (h *BetPlacedHandler) name() string { return "BetPlaced" }
+
+func (h *BetPlacedHandler) Topic0() common.Hash {
+	return h.contractABI.Events["BetPlaced"].ID
+}
+
+func (h *BetPlacedHandler) Handle(ctx context.Context, tx *sql.Tx, vLog types.Log) error {
+	var event BetPlacedEvent
+	event.Player = common.HexToAddress(vLog.Topics[1].Hex())
+
+	if err := h.contractABI.UnpackIntoInterface(&event, "BetPlaced", vLog.Data); err != nil {
+		return fmt.Errorf("unpack BetPlaced: %w", err)
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO bets (user_id, tx_hash, log_index, block_number, amount)
+		SELECT u.id, $1, $2, $3, $4
+		FROM users u
+		WHERE u.wallet_address = $5
+		ON CONFLICT (tx_hash, log_index) DO NOTHING
+	`,
+		vLog.TxHash.Hex(),
+		vLog.Index,
+		vLog.BlockNumber,
+		event.Amount.String(),
+		strings.ToLower(event.Player.Hex()),
+	)
+	if err != nil {
+		return fmt.Errorf("insert bet: %w", err)
+	}
+	return nil
+}
+
+// DepositMadeEvent mirrors the DepositMade event's non-indexed fields.
+type DepositMadeEvent struct {
+	User   common.Address
+	Amount *big.Int
+}
+
+// DepositMadeHandler records an on-chain deposit into the house balance.
+type DepositMadeHandler struct {
+	contractABI abi.ABI
+}
+
+func NewDepositMadeHandler(contractABI abi.ABI) *DepositMadeHandler {
+	return &DepositMadeHandler{contractABI: contractABI}
+}
+
+func (h *DepositMadeHandler) name() string { return "DepositMade" }
+
+func (h *DepositMadeHandler) Topic0() common.Hash {
+	return h.contractABI.Events["DepositMade"].ID
+}
+
+func (h *DepositMadeHandler) Handle(ctx context.Context, tx *sql.Tx, vLog types.Log) error {
+	var event DepositMadeEvent
+	event.User = common.HexToAddress(vLog.Topics[1].Hex())
+
+	if err := h.contractABI.UnpackIntoInterface(&event, "DepositMade", vLog.Data); err != nil {
+		return fmt.Errorf("unpack DepositMade: %w", err)
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO deposits (user_id, tx_hash, log_index, block_number, amount)
+		SELECT u.id, $1, $2, $3, $4
+		FROM users u
+		WHERE u.wallet_address = $5
+		ON CONFLICT (tx_hash, log_index) DO NOTHING
+	`,
+		vLog.TxHash.Hex(),
+		vLog.Index,
+		vLog.BlockNumber,
+		event.Amount.String(),
+		strings.ToLower(event.User.Hex()),
+	)
+	if err != nil {
+		return fmt.Errorf("insert deposit: %w", err)
+	}
+	return nil
+}
+
+// WithdrawalRequestedEvent mirrors the WithdrawalRequested event's
+// non-indexed fields.
+type WithdrawalRequestedEvent struct {
+	User      common.Address
+	Amount    *big.Int
+	RequestID *big.Int
+}
+
+// WithdrawalRequestedHandler records a player's withdrawal request.
+type WithdrawalRequestedHandler struct {
+	contractABI abi.ABI
+}
+
+func NewWithdrawalRequestedHandler(contractABI abi.ABI) *WithdrawalRequestedHandler {
+	return &WithdrawalRequestedHandler{contractABI: contractABI}
+}
+
+func (h *WithdrawalRequestedHandler) name() string { return "WithdrawalRequested" }
+
+func (h *WithdrawalRequestedHandler) Topic0() common.Hash {
+	return h.contractABI.Events["WithdrawalRequested"].ID
+}
+
+func (h *WithdrawalRequestedHandler) Handle(ctx context.Context, tx *sql.Tx, vLog types.Log) error {
+	var event WithdrawalRequestedEvent
+	event.User = common.HexToAddress(vLog.Topics[1].Hex())
+
+	if err := h.contractABI.UnpackIntoInterface(&event, "WithdrawalRequested", vLog.Data); err != nil {
+		return fmt.Errorf("unpack WithdrawalRequested: %w", err)
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO withdrawals (user_id, tx_hash, log_index, block_number, amount, request_id)
+		SELECT u.id, $1, $2, $3, $4, $5
+		FROM users u
+		WHERE u.wallet_address = $6
+		ON CONFLICT (tx_hash, log_index) DO NOTHING
+	`,
+		vLog.TxHash.Hex(),
+		vLog.Index,
+		vLog.BlockNumber,
+		event.Amount.String(),
+		event.RequestID.String(),
+		strings.ToLower(event.User.Hex()),
+	)
+	if err != nil {
+		return fmt.Errorf("insert withdrawal: %w", err)
+	}
+	return nil
+}
+
+// ReferralPaidEvent mirrors the ReferralPaid event's non-indexed fields.
+type ReferralPaidEvent struct {
+	Referrer common.Address
+	Referee  common.Address
+	Amount   *big.Int
+	Tier     uint8
+}
+
+// ReferralPaidHandler records the contract's own on-chain referral payout.
+// This is distinct from the off-chain referral_earnings ledger the indexer
+// computes independently.
+type ReferralPaidHandler struct {
+	contractABI abi.ABI
+}
+
+func NewReferralPaidHandler(contractABI abi.ABI) *ReferralPaidHandler {
+	return &ReferralPaidHandler{contractABI: contractABI}
+}
+
+func (h *ReferralPaidHandler) name() string { return "ReferralPaid" }
+
+func (h *ReferralPaidHandler) Topic0() common.Hash {
+	return h.contractABI.Events["ReferralPaid"].ID
+}
+
+func (h *ReferralPaidHandler) Handle(ctx context.Context, tx *sql.Tx, vLog types.Log) error {
+	var event ReferralPaidEvent
+	event.Referrer = common.HexToAddress(vLog.Topics[1].Hex())
+	event.Referee = common.HexToAddress(vLog.Topics[2].Hex())
+
+	if err := h.contractABI.UnpackIntoInterface(&event, "ReferralPaid", vLog.Data); err != nil {
+		return fmt.Errorf("unpack ReferralPaid: %w", err)
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO referral_payments (referrer_id, referee_id, tx_hash, log_index, block_number, amount, tier)
+		SELECT r.id, e.id, $1, $2, $3, $4, $5
+		FROM users r, users e
+		WHERE r.wallet_address = $6 AND e.wallet_address = $7
+		ON CONFLICT (tx_hash, log_index) DO NOTHING
+	`,
+		vLog.TxHash.Hex(),
+		vLog.Index,
+		vLog.BlockNumber,
+		event.Amount.String(),
+		event.Tier,
+		strings.ToLower(event.Referrer.Hex()),
+		strings.ToLower(event.Referee.Hex()),
+	)
+	if err != nil {
+		return fmt.Errorf("insert referral_payments: %w", err)
+	}
+	return nil
+}
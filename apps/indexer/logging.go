@@ -0,0 +1,19 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// logger is the indexer's structured logger. JSON output keeps production
+// logs grep-friendly by field (tx_hash, block_number, player, ...) instead
+// of parsing free-form text.
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// fatal logs msg at error level with the given fields, then exits.
+// Used in place of log.Fatal for startup failures the process can't recover
+// from.
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
@@ -3,8 +3,6 @@ package main
 import (
 	"context"
 	"database/sql"
-	"fmt"
-	"log"
 	"math/big"
 	"os"
 	"os/signal"
@@ -23,33 +21,20 @@ import (
 
 // RiseJack contract events
 const riseJackABI = `[
-	{"anonymous":false,"inputs":[{"indexed":true,"name":"player","type":"address"},{"indexed":false,"name":"betAmount","type":"uint256"},{"indexed":false,"name":"payout","type":"uint256"},{"indexed":false,"name":"outcome","type":"uint8"}],"name":"GameEnded","type":"event"}
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"player","type":"address"},{"indexed":false,"name":"betAmount","type":"uint256"},{"indexed":false,"name":"payout","type":"uint256"},{"indexed":false,"name":"outcome","type":"uint8"}],"name":"GameEnded","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"player","type":"address"},{"indexed":false,"name":"betAmount","type":"uint256"}],"name":"GameStarted","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"player","type":"address"},{"indexed":false,"name":"amount","type":"uint256"}],"name":"BetPlaced","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"user","type":"address"},{"indexed":false,"name":"amount","type":"uint256"}],"name":"DepositMade","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"user","type":"address"},{"indexed":false,"name":"amount","type":"uint256"},{"indexed":false,"name":"requestId","type":"uint256"}],"name":"WithdrawalRequested","type":"event"},
+	{"anonymous":false,"inputs":[{"indexed":true,"name":"referrer","type":"address"},{"indexed":true,"name":"referee","type":"address"},{"indexed":false,"name":"amount","type":"uint256"},{"indexed":false,"name":"tier","type":"uint8"}],"name":"ReferralPaid","type":"event"}
 ]`
 
-// GameEnded event structure
-type GameEndedEvent struct {
-	Player    common.Address
-	BetAmount *big.Int
-	Payout    *big.Int
-	Outcome   uint8
-}
-
-// Outcome enum
-var outcomeNames = map[uint8]string{
-	0: "lose",
-	1: "win",
-	2: "push",
-	3: "blackjack",
-	4: "surrender",
-}
-
 func main() {
-	fmt.Println("🎰 RiseJack Indexer v1.0.0")
-	fmt.Println("⚡ Target Chain: Rise Testnet (10ms blocks)")
+	logger.Info("risejack indexer starting", "version", "1.0.0", "chain", "rise-testnet")
 
 	// Load environment
 	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
+		logger.Info("no .env file found, using environment variables")
 	}
 
 	// Get configuration
@@ -58,43 +43,43 @@ func main() {
 		rpcURL = os.Getenv("RISE_RPC_URL")
 	}
 	if rpcURL == "" {
-		log.Fatal("RISE_WSS_URL or RISE_RPC_URL environment variable required")
+		fatal("RISE_WSS_URL or RISE_RPC_URL environment variable required")
 	}
 
 	contractAddr := os.Getenv("RISEJACK_CONTRACT_ADDRESS")
 	if contractAddr == "" {
-		log.Fatal("RISEJACK_CONTRACT_ADDRESS environment variable required")
+		fatal("RISEJACK_CONTRACT_ADDRESS environment variable required")
 	}
 
 	dbURL := os.Getenv("DATABASE_URL")
 	if dbURL == "" {
-		log.Fatal("DATABASE_URL environment variable required")
+		fatal("DATABASE_URL environment variable required")
 	}
 
 	// Connect to database
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("failed to connect to database", "error", err)
 	}
 	defer db.Close()
 
 	if err := db.Ping(); err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+		fatal("failed to ping database", "error", err)
 	}
-	fmt.Println("✅ Connected to PostgreSQL")
+	logger.Info("connected to postgres")
 
 	// Connect to Rise Chain
 	client, err := ethclient.Dial(rpcURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to Rise Chain: %v", err)
+		fatal("failed to connect to rise chain", "error", err)
 	}
 	defer client.Close()
-	fmt.Printf("✅ Connected to Rise Chain at %s\n", rpcURL)
+	logger.Info("connected to rise chain", "rpc_url", rpcURL)
 
 	// Parse contract ABI
 	parsedABI, err := abi.JSON(strings.NewReader(riseJackABI))
 	if err != nil {
-		log.Fatalf("Failed to parse ABI: %v", err)
+		fatal("failed to parse abi", "error", err)
 	}
 
 	// Setup context with cancellation
@@ -105,59 +90,164 @@ func main() {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	registry := NewHandlerRegistry(common.HexToAddress(contractAddr), client,
+		NewGameEndedHandler(parsedABI),
+		NewGameStartedHandler(parsedABI),
+		NewBetPlacedHandler(parsedABI),
+		NewDepositMadeHandler(parsedABI),
+		NewWithdrawalRequestedHandler(parsedABI),
+		NewReferralPaidHandler(parsedABI),
+	)
+
+	go serveMetrics(ctx, db, client)
+
 	// Start indexing
-	go indexEvents(ctx, client, db, common.HexToAddress(contractAddr), parsedABI)
+	go indexEvents(ctx, client, db, common.HexToAddress(contractAddr), registry, rpcURL)
 
 	<-stop
-	log.Println("\n🛑 Shutting down indexer...")
+	logger.Info("shutting down indexer")
 	cancel()
 	time.Sleep(time.Second) // Allow goroutines to cleanup
 }
 
-func indexEvents(ctx context.Context, client *ethclient.Client, db *sql.DB, contractAddr common.Address, contractABI abi.ABI) {
-	fmt.Printf("📡 Listening for events on contract: %s\n", contractAddr.Hex())
+// scanCheckpointSig keys the checkpoint row that tracks overall backfill and
+// streaming progress, independent of the per-event checkpoints each
+// EventHandler records when it processes a log.
+const scanCheckpointSig = "_scan"
+
+func indexEvents(ctx context.Context, client *ethclient.Client, db *sql.DB, contractAddr common.Address, registry *HandlerRegistry, rpcURL string) {
+	logger.Info("listening for events", "contract", contractAddr.Hex())
 
 	// Create filter query
 	query := ethereum.FilterQuery{
 		Addresses: []common.Address{contractAddr},
 	}
 
-	// Subscribe to new logs
-	logs := make(chan types.Log)
-	sub, err := client.SubscribeFilterLogs(ctx, query, logs)
+	// Subscribe before backfilling so that any logs emitted while we're
+	// catching up are buffered instead of lost.
+	logs := make(chan types.Log, 256)
+	sub, subErr := client.SubscribeFilterLogs(ctx, query, logs)
+	if subErr != nil {
+		logger.Warn("websocket subscription unavailable during backfill", "error", subErr)
+	}
+
+	var buf logBuffer
+	stopBuffering := make(chan struct{})
+	if subErr == nil {
+		go func() {
+			for {
+				select {
+				case <-stopBuffering:
+					return
+				case vLog := <-logs:
+					buf.add(vLog)
+				case err := <-sub.Err():
+					logger.Warn("subscription error while buffering during backfill", "error", err)
+					return
+				}
+			}
+		}()
+	}
+
+	fromBlock := backfillStartBlock(db, contractAddr)
+	logger.Info("backfilling from block", "from_block", fromBlock)
+
+	head, err := runBackfill(ctx, client, db, contractAddr, scanCheckpointSig, fromBlock, func(vLog types.Log) error {
+		return registry.Dispatch(ctx, db, vLog)
+	})
 	if err != nil {
+		logger.Warn("backfill stopped early", "error", err)
+		// head is the first block the scan did not finish.
+		if head > 0 {
+			head--
+		}
+	} else {
+		logger.Info("backfill complete", "block_number", head)
+	}
+
+	close(stopBuffering)
+
+	// Replay anything that arrived over the subscription mid-backfill,
+	// skipping logs the scan above already applied.
+	for _, vLog := range buf.drain() {
+		if vLog.BlockNumber <= head {
+			continue
+		}
+		if err := registry.Dispatch(ctx, db, vLog); err != nil {
+			logger.Warn("failed to dispatch buffered log", "tx_hash", vLog.TxHash.Hex(), "error", err)
+		}
+	}
+
+	if subErr != nil {
+		setConnState(StatePollingFallback)
 		// Fallback to polling if WebSocket not available
-		log.Printf("WebSocket subscription failed, falling back to polling: %v", err)
-		pollEvents(ctx, client, db, contractAddr, contractABI)
+		pollEvents(ctx, client, db, contractAddr, registry)
 		return
 	}
-	defer sub.Unsubscribe()
+	sub.Unsubscribe()
+
+	go runFinalityTicker(ctx, client, db)
 
-	fmt.Println("📡 WebSocket subscription active")
+	// Hands off to the supervisor, which owns reconnects for the rest of the
+	// process's life.
+	superviseSubscription(ctx, rpcURL, client, db, contractAddr, registry, head)
+}
+
+// runFinalityTicker periodically credits XP for games whose blocks have
+// cleared the configured finality lag.
+func runFinalityTicker(ctx context.Context, client *ethclient.Client, db *sql.DB) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case err := <-sub.Err():
-			log.Printf("Subscription error: %v", err)
-			// Attempt to reconnect
-			time.Sleep(5 * time.Second)
-			return
-		case vLog := <-logs:
-			processLog(db, contractABI, vLog)
+		case <-ticker.C:
+			head, err := client.BlockNumber(ctx)
+			if err != nil {
+				rpcErrorsTotal.WithLabelValues("BlockNumber").Inc()
+				logger.Warn("finality ticker failed to fetch head block", "error", err)
+				continue
+			}
+			if from := lastRecordedBlock.Load() + 1; from <= head {
+				if err := recordBlockRange(ctx, client, db, from, head); err != nil {
+					logger.Warn("finality ticker failed to record block range", "from_block", from, "to_block", head, "error", err)
+				}
+			}
+			if err := creditFinalizedXP(db, head); err != nil {
+				logger.Warn("failed to credit finalized XP", "block_number", head, "error", err)
+			}
+			recordProgress(head, head)
 		}
 	}
 }
 
-func pollEvents(ctx context.Context, client *ethclient.Client, db *sql.DB, contractAddr common.Address, contractABI abi.ABI) {
-	fmt.Println("📡 Polling mode active (every 5 seconds)")
-
-	// Get last processed block from DB
-	var lastBlock uint64 = 0
-	err := db.QueryRow("SELECT COALESCE(MAX(block_number), 0) FROM games").Scan(&lastBlock)
+// backfillStartBlock determines where a backfill scan should resume: the
+// durable checkpoint if one exists, otherwise the configured START_BLOCK
+// (typically the contract's deployment height), otherwise block 0.
+func backfillStartBlock(db *sql.DB, contractAddr common.Address) uint64 {
+	cp, err := loadCheckpoint(db, contractAddr.Hex(), scanCheckpointSig)
 	if err != nil {
-		log.Printf("Failed to get last block: %v", err)
+		logger.Warn("failed to load checkpoint, starting from START_BLOCK", "error", err)
+	} else if cp.LastBlock > 0 {
+		return cp.LastBlock + 1
+	}
+
+	if start := envUint64("START_BLOCK"); start > 0 {
+		return start
+	}
+	return 0
+}
+
+func pollEvents(ctx context.Context, client *ethclient.Client, db *sql.DB, contractAddr common.Address, registry *HandlerRegistry) {
+	logger.Info("polling mode active", "interval", "5s")
+
+	// Resume from the durable checkpoint rather than MAX(block_number) in
+	// games, since the checkpoint also covers windows that produced no rows.
+	lastBlock := backfillStartBlock(db, contractAddr)
+	if lastBlock > 0 {
+		lastBlock--
 	}
 
 	ticker := time.NewTicker(5 * time.Second)
@@ -170,7 +260,8 @@ func pollEvents(ctx context.Context, client *ethclient.Client, db *sql.DB, contr
 		case <-ticker.C:
 			currentBlock, err := client.BlockNumber(ctx)
 			if err != nil {
-				log.Printf("Failed to get block number: %v", err)
+				rpcErrorsTotal.WithLabelValues("BlockNumber").Inc()
+				logger.Warn("failed to get block number", "error", err)
 				continue
 			}
 
@@ -186,91 +277,34 @@ func pollEvents(ctx context.Context, client *ethclient.Client, db *sql.DB, contr
 
 			logs, err := client.FilterLogs(ctx, query)
 			if err != nil {
-				log.Printf("Failed to filter logs: %v", err)
+				rpcErrorsTotal.WithLabelValues("FilterLogs").Inc()
+				logger.Warn("failed to filter logs", "error", err)
 				continue
 			}
 
 			for _, vLog := range logs {
-				processLog(db, contractABI, vLog)
+				if err := registry.Dispatch(ctx, db, vLog); err != nil {
+					logger.Warn("failed to dispatch log", "tx_hash", vLog.TxHash.Hex(), "error", err)
+				}
 			}
 
-			if len(logs) > 0 {
-				fmt.Printf("Processed %d events from blocks %d-%d\n", len(logs), lastBlock+1, currentBlock)
+			if err := recordBlockRange(ctx, client, db, lastBlock+1, currentBlock); err != nil {
+				logger.Warn("failed to record block range", "from_block", lastBlock+1, "to_block", currentBlock, "error", err)
 			}
-			lastBlock = currentBlock
-		}
-	}
-}
-
-func processLog(db *sql.DB, contractABI abi.ABI, vLog types.Log) {
-	// Check if this is a GameEnded event
-	gameEndedEvent := contractABI.Events["GameEnded"]
-	if len(vLog.Topics) == 0 || vLog.Topics[0] != gameEndedEvent.ID {
-		return
-	}
 
-	// Decode event
-	var event GameEndedEvent
-	event.Player = common.HexToAddress(vLog.Topics[1].Hex())
-
-	err := contractABI.UnpackIntoInterface(&event, "GameEnded", vLog.Data)
-	if err != nil {
-		log.Printf("Failed to unpack event: %v", err)
-		return
-	}
-
-	outcome := outcomeNames[event.Outcome]
-	pnl := new(big.Int).Sub(event.Payout, event.BetAmount)
-
-	fmt.Printf("🎲 GameEnded: player=%s bet=%s payout=%s outcome=%s\n",
-		event.Player.Hex()[:10]+"...",
-		event.BetAmount.String(),
-		event.Payout.String(),
-		outcome,
-	)
-
-	// Insert into database
-	_, err = db.Exec(`
-		INSERT INTO games (
-			user_id, game_type, tx_hash, block_number,
-			bet_amount, currency, payout, pnl, outcome,
-			started_at, ended_at
-		)
-		SELECT 
-			u.id, 'blackjack', $1, $2,
-			$3, 'ETH', $4, $5, $6,
-			NOW(), NOW()
-		FROM users u
-		WHERE u.wallet_address = $7
-		ON CONFLICT (tx_hash) DO NOTHING
-	`,
-		vLog.TxHash.Hex(),
-		vLog.BlockNumber,
-		event.BetAmount.String(),
-		event.Payout.String(),
-		pnl.String(),
-		outcome,
-		strings.ToLower(event.Player.Hex()),
-	)
-
-	if err != nil {
-		log.Printf("Failed to insert game: %v", err)
-		return
-	}
+			if err := saveCheckpoint(db, contractAddr.Hex(), scanCheckpointSig, currentBlock, 0); err != nil {
+				logger.Warn("failed to save checkpoint", "block_number", currentBlock, "error", err)
+			}
 
-	// Update user XP (10 XP per game)
-	_, err = db.Exec(`
-		UPDATE users SET 
-			xp = xp + 10,
-			level = CASE WHEN xp + 10 >= level * 100 THEN level + 1 ELSE level END,
-			updated_at = NOW(),
-			last_seen_at = NOW()
-		WHERE wallet_address = $1
-	`, strings.ToLower(event.Player.Hex()))
+			if err := creditFinalizedXP(db, currentBlock); err != nil {
+				logger.Warn("failed to credit finalized XP", "block_number", currentBlock, "error", err)
+			}
 
-	if err != nil {
-		log.Printf("Failed to update user XP: %v", err)
+			if len(logs) > 0 {
+				logger.Info("processed events", "count", len(logs), "from_block", lastBlock+1, "to_block", currentBlock)
+			}
+			recordProgress(currentBlock, currentBlock)
+			lastBlock = currentBlock
+		}
 	}
-
-	// TODO: Process referral earnings here
 }
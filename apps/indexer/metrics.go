@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	logsProcessedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "risejack_logs_processed_total",
+		Help: "Total number of contract logs dispatched to an EventHandler, by event and outcome.",
+	}, []string{"event", "outcome"})
+
+	dbInsertErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "risejack_db_insert_errors_total",
+		Help: "Total number of failed handler writes or checkpoint commits.",
+	})
+
+	rpcErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "risejack_rpc_errors_total",
+		Help: "Total number of failed RPC calls to the chain node, by method.",
+	}, []string{"method"})
+
+	reconnectsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "risejack_reconnects_total",
+		Help: "Total number of times the WebSocket subscription successfully reconnected after a drop.",
+	})
+
+	headBlockGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "risejack_head_block",
+		Help: "Most recently observed chain head block number.",
+	})
+
+	indexedBlockGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "risejack_indexed_block",
+		Help: "Most recently indexed block number.",
+	})
+
+	blockLagGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "risejack_block_lag",
+		Help: "Difference between the chain head and the most recently indexed block.",
+	})
+
+	logProcessingSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "risejack_log_processing_seconds",
+		Help:    "Time to verify canonicality, run a handler, and commit its checkpoint for one log.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	connectionStateGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "risejack_connection_state",
+		Help: "Live subscription state: 0=connected, 1=reconnecting, 2=polling_fallback.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		logsProcessedTotal,
+		dbInsertErrorsTotal,
+		rpcErrorsTotal,
+		reconnectsTotal,
+		headBlockGauge,
+		indexedBlockGauge,
+		blockLagGauge,
+		logProcessingSeconds,
+		connectionStateGauge,
+	)
+}
+
+// connectionStateValue maps a ConnState to the numeric value connectionStateGauge reports.
+func connectionStateValue(s ConnState) float64 {
+	switch s {
+	case StateConnected:
+		return 0
+	case StatePollingFallback:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// lastHeadBlock and lastIndexedBlock back the /healthz lag check; Prometheus
+// gauges don't expose a getter, so recordProgress keeps these in sync
+// alongside the gauges themselves.
+var (
+	lastHeadBlock    atomic.Uint64
+	lastIndexedBlock atomic.Uint64
+)
+
+// recordProgress updates the head/indexed/lag gauges, called whenever the
+// indexer learns a new chain head or finishes indexing a block.
+func recordProgress(head, indexed uint64) {
+	lastHeadBlock.Store(head)
+	lastIndexedBlock.Store(indexed)
+	headBlockGauge.Set(float64(head))
+	indexedBlockGauge.Set(float64(indexed))
+	if head > indexed {
+		blockLagGauge.Set(float64(head - indexed))
+	} else {
+		blockLagGauge.Set(0)
+	}
+}
+
+const defaultHealthMaxBlockLag = uint64(100)
+
+// healthThresholdBlocks is the block lag beyond which /healthz reports
+// unhealthy, configurable via HEALTH_MAX_BLOCK_LAG.
+func healthThresholdBlocks() uint64 {
+	if v := envUint64("HEALTH_MAX_BLOCK_LAG"); v > 0 {
+		return v
+	}
+	return defaultHealthMaxBlockLag
+}
+
+const defaultMetricsPort = "9100"
+
+// serveMetrics runs the /metrics and /healthz HTTP endpoints on METRICS_PORT
+// until ctx is cancelled.
+func serveMetrics(ctx context.Context, db *sql.DB, client *ethclient.Client) {
+	port := defaultMetricsPort
+	if v := os.Getenv("METRICS_PORT"); v != "" {
+		port = v
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		handleHealthz(w, r, db, client)
+	})
+
+	srv := &http.Server{Addr: ":" + port, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("metrics server listening", "port", port)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("metrics server failed", "error", err)
+	}
+}
+
+// healthzBody is the JSON shape returned by /healthz, surfacing the live
+// subscription state alongside the usual liveness checks.
+type healthzBody struct {
+	Status          string `json:"status"`
+	ConnectionState string `json:"connection_state"`
+	HeadBlock       uint64 `json:"head_block"`
+	IndexedBlock    uint64 `json:"indexed_block"`
+	BlockLag        uint64 `json:"block_lag"`
+}
+
+// handleHealthz reports 200 while the database and chain RPC are reachable
+// and the indexer isn't more than healthThresholdBlocks() behind head, 503
+// otherwise. The response body always includes the live subscription state
+// (connected / reconnecting / polling_fallback) regardless of status.
+func handleHealthz(w http.ResponseWriter, r *http.Request, db *sql.DB, client *ethclient.Client) {
+	writeJSON := func(status int, body healthzBody) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+
+	state := CurrentConnectionState()
+
+	if err := db.Ping(); err != nil {
+		writeJSON(http.StatusServiceUnavailable, healthzBody{Status: "database unreachable", ConnectionState: string(state)})
+		return
+	}
+
+	head, err := client.BlockNumber(r.Context())
+	if err != nil {
+		rpcErrorsTotal.WithLabelValues("BlockNumber").Inc()
+		writeJSON(http.StatusServiceUnavailable, healthzBody{Status: "chain rpc unreachable", ConnectionState: string(state)})
+		return
+	}
+	recordProgress(head, lastIndexedBlock.Load())
+
+	indexed := lastIndexedBlock.Load()
+	var lag uint64
+	if head > indexed {
+		lag = head - indexed
+	}
+
+	if lag > healthThresholdBlocks() {
+		writeJSON(http.StatusServiceUnavailable, healthzBody{
+			Status: "indexer behind head", ConnectionState: string(state),
+			HeadBlock: head, IndexedBlock: indexed, BlockLag: lag,
+		})
+		return
+	}
+
+	writeJSON(http.StatusOK, healthzBody{
+		Status: "ok", ConnectionState: string(state),
+		HeadBlock: head, IndexedBlock: indexed, BlockLag: lag,
+	})
+}
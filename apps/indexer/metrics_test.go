@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestConnectionStateValue(t *testing.T) {
+	tests := []struct {
+		state ConnState
+		want  float64
+	}{
+		{StateConnected, 0},
+		{StateReconnecting, 1},
+		{StatePollingFallback, 2},
+		{ConnState("unknown"), 1},
+	}
+
+	for _, tt := range tests {
+		if got := connectionStateValue(tt.state); got != tt.want {
+			t.Errorf("connectionStateValue(%q) = %v, want %v", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestHealthThresholdBlocks(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("HEALTH_MAX_BLOCK_LAG", "")
+		if got := healthThresholdBlocks(); got != defaultHealthMaxBlockLag {
+			t.Errorf("got %d, want %d", got, defaultHealthMaxBlockLag)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		t.Setenv("HEALTH_MAX_BLOCK_LAG", "10")
+		if got := healthThresholdBlocks(); got != 10 {
+			t.Errorf("got %d, want 10", got)
+		}
+	})
+}
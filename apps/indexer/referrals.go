@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultReferralMaxTiers = 3
+	defaultReferralTierPcts = "10,5,2" // percent per tier, direct referrer (tier 1) first
+	defaultReferralBase     = "loss"
+)
+
+// referralMaxTiers returns how many levels of referrer to walk and credit,
+// configurable via REFERRAL_MAX_TIERS.
+func referralMaxTiers() int {
+	if v := envUint64("REFERRAL_MAX_TIERS"); v > 0 {
+		return int(v)
+	}
+	return defaultReferralMaxTiers
+}
+
+// referralTierPercentsBP returns the percentage credited at each tier, in
+// basis points so the payout math stays in big.Int instead of floats.
+// Configurable via REFERRAL_TIER_PCTS as a comma-separated list of percent
+// values (e.g. "10,5,2"); tiers beyond the configured list earn nothing.
+func referralTierPercentsBP() []int64 {
+	raw := os.Getenv("REFERRAL_TIER_PCTS")
+	if raw == "" {
+		raw = defaultReferralTierPcts
+	}
+
+	parts := strings.Split(raw, ",")
+	bps := make([]int64, 0, len(parts))
+	for _, p := range parts {
+		pct, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			continue
+		}
+		bps = append(bps, int64(pct*100))
+	}
+	return bps
+}
+
+// referralEarningsBase selects which amount referral percentages are taken
+// from, configurable via REFERRAL_EARNINGS_BASE ("bet", "loss", or
+// "payout"). Defaults to "loss" (the realized house edge).
+func referralEarningsBase() string {
+	if v := os.Getenv("REFERRAL_EARNINGS_BASE"); v != "" {
+		return v
+	}
+	return defaultReferralBase
+}
+
+// earningsBase selects betAmount, payout, or their difference according to
+// referralEarningsBase(), used as the amount tier percentages are taken from.
+func earningsBase(betAmount, payout *big.Int) *big.Int {
+	switch referralEarningsBase() {
+	case "bet":
+		return betAmount
+	case "payout":
+		return payout
+	default:
+		return new(big.Int).Sub(betAmount, payout)
+	}
+}
+
+// tierAmount returns bp basis points of base, truncated toward zero like the
+// contract's own integer math.
+func tierAmount(base *big.Int, bp int64) *big.Int {
+	amount := new(big.Int).Mul(base, big.NewInt(bp))
+	return amount.Div(amount, big.NewInt(10000))
+}
+
+// processReferralEarnings walks up to referralMaxTiers() levels of referrer
+// starting from playerUserID, crediting each a tier-specific percentage of
+// the configured earnings base into referral_earnings within the caller's
+// transaction. It's a no-op when the house didn't realize an edge on this
+// game.
+func processReferralEarnings(tx *sql.Tx, gameID, playerUserID int64, betAmount, payout *big.Int) error {
+	if payout.Cmp(betAmount) >= 0 {
+		return nil
+	}
+
+	base := earningsBase(betAmount, payout)
+
+	tierPcts := referralTierPercentsBP()
+	maxTiers := referralMaxTiers()
+	if len(tierPcts) < maxTiers {
+		maxTiers = len(tierPcts)
+	}
+
+	referredUser := playerUserID
+	for tier := 1; tier <= maxTiers; tier++ {
+		var referrerID int64
+		err := tx.QueryRow(`SELECT referrer_id FROM referrals WHERE user_id = $1`, referredUser).Scan(&referrerID)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("lookup referrer for user %d: %w", referredUser, err)
+		}
+
+		amount := tierAmount(base, tierPcts[tier-1])
+
+		if amount.Sign() > 0 {
+			if _, err := tx.Exec(`
+				INSERT INTO referral_earnings (game_id, referrer_id, tier, amount, currency)
+				VALUES ($1, $2, $3, $4, 'ETH')
+				ON CONFLICT (game_id, tier) DO NOTHING
+			`, gameID, referrerID, tier, amount.String()); err != nil {
+				return fmt.Errorf("insert referral_earnings tier %d: %w", tier, err)
+			}
+		}
+
+		referredUser = referrerID
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestTierAmount(t *testing.T) {
+	tests := []struct {
+		name string
+		base int64
+		bp   int64
+		want int64
+	}{
+		{"ten percent", 1000, 1000, 100},
+		{"five percent", 1000, 500, 50},
+		{"rounds down", 999, 1000, 99},
+		{"zero base", 0, 1000, 0},
+		{"zero bp", 1000, 0, 0},
+		{"below one basis point", 1, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tierAmount(big.NewInt(tt.base), tt.bp)
+			if got.Cmp(big.NewInt(tt.want)) != 0 {
+				t.Errorf("tierAmount(%d, %d) = %s, want %d", tt.base, tt.bp, got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestEarningsBase(t *testing.T) {
+	betAmount := big.NewInt(1000)
+	payout := big.NewInt(400)
+
+	tests := []struct {
+		name   string
+		envVal string
+		want   int64
+	}{
+		{"defaults to loss", "", 600},
+		{"bet", "bet", 1000},
+		{"payout", "payout", 400},
+		{"loss explicit", "loss", 600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REFERRAL_EARNINGS_BASE", tt.envVal)
+			got := earningsBase(betAmount, payout)
+			if got.Cmp(big.NewInt(tt.want)) != 0 {
+				t.Errorf("earningsBase() = %s, want %d", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestReferralTierPercentsBP(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("REFERRAL_TIER_PCTS", "")
+		got := referralTierPercentsBP()
+		want := []int64{1000, 500, 200}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("tier %d: got %d, want %d", i, got[i], want[i])
+			}
+		}
+	})
+
+	t.Run("custom list with a fractional percent", func(t *testing.T) {
+		t.Setenv("REFERRAL_TIER_PCTS", "7.5, 2")
+		got := referralTierPercentsBP()
+		want := []int64{750, 200}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("tier %d: got %d, want %d", i, got[i], want[i])
+			}
+		}
+	})
+
+	t.Run("unparseable entries are skipped", func(t *testing.T) {
+		t.Setenv("REFERRAL_TIER_PCTS", "10,nope,2")
+		got := referralTierPercentsBP()
+		want := []int64{1000, 200}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestReferralMaxTiers(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("REFERRAL_MAX_TIERS", "")
+		if got := referralMaxTiers(); got != defaultReferralMaxTiers {
+			t.Errorf("got %d, want %d", got, defaultReferralMaxTiers)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		t.Setenv("REFERRAL_MAX_TIERS", "5")
+		if got := referralMaxTiers(); got != 5 {
+			t.Errorf("got %d, want 5", got)
+		}
+	})
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const defaultFinalityLag = uint64(5)
+
+// finalityLag returns how many blocks must pass before a block is treated as
+// safe to XP-credit, configurable via FINALITY_LAG since Rise Testnet's
+// 10ms blocks make reorgs far more frequent than on slower chains.
+func finalityLag() uint64 {
+	if v := envUint64("FINALITY_LAG"); v > 0 {
+		return v
+	}
+	return defaultFinalityLag
+}
+
+// storedBlockHash returns the block_hash previously recorded for
+// blockNumber, or "" if we've never indexed it.
+func storedBlockHash(db *sql.DB, blockNumber uint64) (string, error) {
+	var hash string
+	err := db.QueryRow(`SELECT block_hash FROM indexed_blocks WHERE block_number = $1`, blockNumber).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
+
+// recordIndexedBlock persists the canonical hash we've now seen for
+// blockNumber so the next log can verify continuity against it.
+func recordIndexedBlock(db *sql.DB, blockNumber uint64, blockHash, parentHash string) error {
+	_, err := db.Exec(`
+		INSERT INTO indexed_blocks (block_number, block_hash, parent_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (block_number) DO UPDATE SET block_hash = $2, parent_hash = $3
+	`, blockNumber, blockHash, parentHash)
+	if err == nil {
+		markBlockRecorded(blockNumber)
+	}
+	return err
+}
+
+// lastRecordedBlock tracks the highest block number recordBlockRange/
+// recordIndexedBlock has written, so the finality ticker knows where to
+// resume recording without a DB round trip.
+var lastRecordedBlock atomic.Uint64
+
+func markBlockRecorded(blockNumber uint64) {
+	for {
+		cur := lastRecordedBlock.Load()
+		if blockNumber <= cur || lastRecordedBlock.CompareAndSwap(cur, blockNumber) {
+			return
+		}
+	}
+}
+
+// recordBlockRange records the canonical hash of every block in [from, to].
+// Contract events don't occur in every block, so relying solely on the
+// blocks a dispatched log happens to land in leaves most of the chain
+// unrecorded and the parent-hash check in ensureCanonical silently unable
+// to compare — this is what actually makes reorg detection effective
+// between logs, during backfill and on every new head.
+func recordBlockRange(ctx context.Context, client *ethclient.Client, db *sql.DB, from, to uint64) error {
+	for b := from; b <= to; b++ {
+		header, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(b))
+		if err != nil {
+			rpcErrorsTotal.WithLabelValues("HeaderByNumber").Inc()
+			return fmt.Errorf("fetch header %d: %w", b, err)
+		}
+		if err := recordIndexedBlock(db, b, header.Hash().Hex(), header.ParentHash.Hex()); err != nil {
+			return fmt.Errorf("record block %d: %w", b, err)
+		}
+	}
+	return nil
+}
+
+// ensureCanonical verifies that vLog's block still chains to what we've
+// already indexed. If the block hash recorded for blockNumber-1 doesn't
+// match the parent hash of vLog's block, it walks backward to find the last
+// block both sides agree on, rolls back everything indexed from there
+// forward, and lets the caller's normal scan path re-apply the canonical
+// range.
+func ensureCanonical(ctx context.Context, client *ethclient.Client, db *sql.DB, vLog types.Log) error {
+	header, err := client.HeaderByHash(ctx, vLog.BlockHash)
+	if err != nil {
+		rpcErrorsTotal.WithLabelValues("HeaderByHash").Inc()
+		return fmt.Errorf("fetch header %s: %w", vLog.BlockHash.Hex(), err)
+	}
+
+	if vLog.BlockNumber > 0 {
+		stored, err := storedBlockHash(db, vLog.BlockNumber-1)
+		if err != nil {
+			return fmt.Errorf("lookup stored hash for block %d: %w", vLog.BlockNumber-1, err)
+		}
+
+		if stored != "" && stored != header.ParentHash.Hex() {
+			ancestor, err := findCommonAncestor(ctx, client, db, vLog.BlockNumber-1)
+			if err != nil {
+				return fmt.Errorf("find common ancestor: %w", err)
+			}
+			logger.Warn("reorg detected, rolling back", "from_block", ancestor+1)
+			if err := rollbackFrom(db, ancestor+1); err != nil {
+				return fmt.Errorf("rollback from block %d: %w", ancestor+1, err)
+			}
+		}
+	}
+
+	return recordIndexedBlock(db, vLog.BlockNumber, vLog.BlockHash.Hex(), header.ParentHash.Hex())
+}
+
+// findCommonAncestor walks backward from suspectBlock, comparing our stored
+// block hash against the chain's canonical hash at each height, until they
+// agree. It returns the last block number both sides share.
+func findCommonAncestor(ctx context.Context, client *ethclient.Client, db *sql.DB, suspectBlock uint64) (uint64, error) {
+	for b := suspectBlock; b > 0; b-- {
+		stored, err := storedBlockHash(db, b)
+		if err != nil {
+			return 0, err
+		}
+		if stored == "" {
+			return b, nil
+		}
+
+		header, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(b))
+		if err != nil {
+			rpcErrorsTotal.WithLabelValues("HeaderByNumber").Inc()
+			return 0, err
+		}
+		if header.Hash().Hex() == stored {
+			return b, nil
+		}
+	}
+	return 0, nil
+}
+
+// rollbackFrom deletes every record derived from an orphaned block (games
+// and every per-event table chunk0-3/chunk0-5 added, XP awards, and
+// indexed-block records) from fromBlock onward, reversing each game's XP
+// grant on the affected user before the award row itself is removed.
+// referral_earnings has no block_number column, so it's cleaned up by
+// joining on the game ids being deleted rather than by block range.
+func rollbackFrom(db *sql.DB, fromBlock uint64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT user_id, amount FROM xp_awards WHERE block_number >= $1`, fromBlock)
+	if err != nil {
+		return err
+	}
+	type award struct {
+		userID int64
+		amount int
+	}
+	var awards []award
+	for rows.Next() {
+		var a award
+		if err := rows.Scan(&a.userID, &a.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		awards = append(awards, a)
+	}
+	rows.Close()
+
+	for _, a := range awards {
+		if _, err := tx.Exec(`
+			UPDATE users SET xp = xp - $1,
+				level = CASE WHEN xp - $1 < (level - 1) * 100 THEN GREATEST(level - 1, 1) ELSE level END,
+				updated_at = NOW()
+			WHERE id = $2
+		`, a.amount, a.userID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`
+		DELETE FROM referral_earnings
+		WHERE game_id IN (SELECT id FROM games WHERE block_number >= $1)
+	`, fromBlock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM xp_awards WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM games WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM game_starts WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM bets WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM deposits WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM withdrawals WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM referral_payments WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM indexed_blocks WHERE block_number >= $1`, fromBlock); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// creditFinalizedXP awards the standard per-game XP to any game whose block
+// is at least finalityLag() blocks behind headBlock and hasn't been credited
+// yet, recording each award so a later reorg can reverse it precisely.
+func creditFinalizedXP(db *sql.DB, headBlock uint64) error {
+	lag := finalityLag()
+	if headBlock < lag {
+		return nil
+	}
+	safeBelow := headBlock - lag
+
+	rows, err := db.Query(`
+		SELECT g.user_id, g.tx_hash, g.block_number
+		FROM games g
+		LEFT JOIN xp_awards x ON x.game_tx_hash = g.tx_hash
+		WHERE g.block_number <= $1 AND x.id IS NULL
+	`, safeBelow)
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		userID      int64
+		txHash      string
+		blockNumber uint64
+	}
+	var toCredit []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.userID, &p.txHash, &p.blockNumber); err != nil {
+			rows.Close()
+			return err
+		}
+		toCredit = append(toCredit, p)
+	}
+	rows.Close()
+
+	const xpPerGame = 10
+	for _, p := range toCredit {
+		if err := creditOneAward(db, p.userID, p.txHash, p.blockNumber, xpPerGame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func creditOneAward(db *sql.DB, userID int64, txHash string, blockNumber uint64, amount int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE users SET xp = xp + $1,
+			level = CASE WHEN xp + $1 >= level * 100 THEN level + 1 ELSE level END,
+			updated_at = NOW(), last_seen_at = NOW()
+		WHERE id = $2
+	`, amount, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO xp_awards (user_id, game_tx_hash, block_number, amount)
+		VALUES ($1, $2, $3, $4)
+	`, userID, txHash, blockNumber, amount); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
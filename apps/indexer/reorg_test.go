@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestFinalityLag(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		t.Setenv("FINALITY_LAG", "")
+		if got := finalityLag(); got != defaultFinalityLag {
+			t.Errorf("got %d, want %d", got, defaultFinalityLag)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		t.Setenv("FINALITY_LAG", "20")
+		if got := finalityLag(); got != 20 {
+			t.Errorf("got %d, want 20", got)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// ConnState describes the live event subscription's current state, as
+// surfaced by the health endpoint.
+type ConnState string
+
+const (
+	StateConnected       ConnState = "connected"
+	StateReconnecting    ConnState = "reconnecting"
+	StatePollingFallback ConnState = "polling_fallback"
+)
+
+var connState atomic.Value
+
+func setConnState(s ConnState) {
+	connState.Store(s)
+	connectionStateGauge.Set(connectionStateValue(s))
+}
+
+// CurrentConnectionState returns the indexer's live subscription state.
+func CurrentConnectionState() ConnState {
+	if v, ok := connState.Load().(ConnState); ok {
+		return v
+	}
+	return StateReconnecting
+}
+
+const (
+	reconnectBaseBackoff = time.Second
+	reconnectMaxBackoff  = 60 * time.Second
+)
+
+// jitteredBackoff adds up to 50% random jitter on top of d so a fleet of
+// indexers reconnecting together doesn't hammer the RPC endpoint in lockstep.
+func jitteredBackoff(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// superviseSubscription keeps a live WebSocket subscription running for as
+// long as ctx is alive. On any disconnect it closes the stale subscription,
+// sleeps with jittered exponential backoff, redials the ethclient if the
+// connection underneath it died, re-subscribes, and replays whatever logs
+// arrived in the gap via FilterLogs before resuming streaming.
+func superviseSubscription(
+	ctx context.Context,
+	rpcURL string,
+	client *ethclient.Client,
+	db *sql.DB,
+	contractAddr common.Address,
+	registry *HandlerRegistry,
+	lastSeenBlock uint64,
+) {
+	backoff := reconnectBaseBackoff
+	wasReconnecting := false
+
+	for ctx.Err() == nil {
+		query := ethereum.FilterQuery{Addresses: []common.Address{contractAddr}}
+		logs := make(chan types.Log, 256)
+		sub, err := client.SubscribeFilterLogs(ctx, query, logs)
+		if err != nil {
+			rpcErrorsTotal.WithLabelValues("SubscribeFilterLogs").Inc()
+			logger.Warn("subscription attempt failed", "error", err)
+			setConnState(StateReconnecting)
+			wasReconnecting = true
+
+			if redialed, newClient := redial(rpcURL); redialed {
+				client = newClient
+				registry.SetClient(client)
+			}
+
+			if !sleepOrDone(ctx, jitteredBackoff(backoff)) {
+				return
+			}
+			backoff = doubleBackoff(backoff, reconnectMaxBackoff)
+			continue
+		}
+
+		if head, err := client.BlockNumber(ctx); err == nil && head > lastSeenBlock {
+			if err := replayGap(ctx, client, db, contractAddr, registry, lastSeenBlock+1, head); err != nil {
+				logger.Warn("failed to replay gap after reconnect", "from_block", lastSeenBlock+1, "to_block", head, "error", err)
+			} else {
+				lastSeenBlock = head
+			}
+		}
+
+		setConnState(StateConnected)
+		if wasReconnecting {
+			reconnectsTotal.Inc()
+			wasReconnecting = false
+		}
+		backoff = reconnectBaseBackoff
+		logger.Info("websocket subscription active")
+
+		disconnected := false
+		for !disconnected {
+			select {
+			case <-ctx.Done():
+				sub.Unsubscribe()
+				return
+			case err := <-sub.Err():
+				logger.Warn("subscription error", "error", err)
+				sub.Unsubscribe()
+				setConnState(StateReconnecting)
+				wasReconnecting = true
+				disconnected = true
+			case vLog := <-logs:
+				if err := registry.Dispatch(ctx, db, vLog); err != nil {
+					logger.Warn("failed to dispatch log", "tx_hash", vLog.TxHash.Hex(), "error", err)
+				}
+				if vLog.BlockNumber > lastSeenBlock {
+					lastSeenBlock = vLog.BlockNumber
+				}
+			}
+		}
+
+		if !sleepOrDone(ctx, jitteredBackoff(backoff)) {
+			return
+		}
+		backoff = doubleBackoff(backoff, reconnectMaxBackoff)
+	}
+}
+
+// redial attempts to open a fresh ethclient connection, returning the new
+// client on success. The caller keeps its existing client if this fails, and
+// simply retries on the next loop iteration.
+func redial(rpcURL string) (bool, *ethclient.Client) {
+	newClient, err := ethclient.Dial(rpcURL)
+	if err != nil {
+		logger.Warn("redial failed", "rpc_url", rpcURL, "error", err)
+		return false, nil
+	}
+	return true, newClient
+}
+
+// sleepOrDone waits for d, returning false early if ctx is cancelled first.
+func sleepOrDone(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// replayGap re-scans [from, to] via FilterLogs so logs missed while
+// disconnected aren't lost before live streaming resumes.
+func replayGap(ctx context.Context, client *ethclient.Client, db *sql.DB, contractAddr common.Address, registry *HandlerRegistry, from, to uint64) error {
+	if from > to {
+		return nil
+	}
+	logger.Info("replaying missed blocks after reconnect", "from_block", from, "to_block", to)
+
+	query := ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(from),
+		ToBlock:   new(big.Int).SetUint64(to),
+		Addresses: []common.Address{contractAddr},
+	}
+	logs, err := client.FilterLogs(ctx, query)
+	if err != nil {
+		rpcErrorsTotal.WithLabelValues("FilterLogs").Inc()
+		return err
+	}
+	for _, vLog := range logs {
+		if err := registry.Dispatch(ctx, db, vLog); err != nil {
+			logger.Warn("failed to dispatch replayed log", "tx_hash", vLog.TxHash.Hex(), "error", err)
+		}
+	}
+
+	if err := recordBlockRange(ctx, client, db, from, to); err != nil {
+		logger.Warn("failed to record block range after reconnect", "from_block", from, "to_block", to, "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitteredBackoff(t *testing.T) {
+	t.Run("non-positive passthrough", func(t *testing.T) {
+		if got := jitteredBackoff(0); got != 0 {
+			t.Errorf("got %v, want 0", got)
+		}
+		if got := jitteredBackoff(-time.Second); got != -time.Second {
+			t.Errorf("got %v, want -1s", got)
+		}
+	})
+
+	t.Run("adds up to 50%% jitter", func(t *testing.T) {
+		d := 10 * time.Second
+		for i := 0; i < 100; i++ {
+			got := jitteredBackoff(d)
+			if got < d || got > d+d/2 {
+				t.Fatalf("jitteredBackoff(%v) = %v, want in [%v, %v]", d, got, d, d+d/2)
+			}
+		}
+	})
+}